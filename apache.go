@@ -1,93 +1,59 @@
 package useful
 
 import (
-	"bufio"
-	"errors"
-	"io"
-	"net"
 	"net/http"
-	"strings"
 	"time"
 )
 
-// ErrUnHijackable indicates an unhijackable connection. I.e., (one of)
-// the underlying http.ResponseWriter(s) doesn't support the http.Hijacker
-// interface.
-var ErrUnHijackable = errors.New("A(n) underlying ResponseWriter doesn't support the http.Hijacker interface")
-
-// These format strings correspond with the log formats described in
-// https://httpd.apache.org/docs/2.2/mod/mod_log_config.html
-var (
-	// CommonLog is "%h %l %u %t \"%r\" %>s %b"
-	CommonLog commonLog = "%s - - [%s] \"%s\" %d %d\n"
-
-	// CommonLogWithVHost is "%v %h %l %u %t \"%r\" %>s %b"
-	CommonLogWithVHost commonLogWithVHost = "- %s - - [%s] \"%s\" %d %d\n"
-
-	// NCSALog is
-	// "%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-agent}i\""
-	NCSALog ncsaLog = "%s - - [%s] \"%s\" %d %d \"%s\" \"%s\"\n"
-
-	// RefererLog is "%{Referer}i -> %U"
-	RefererLog refererLog = "%s -> %s\n"
-
-	// AgentLog is "%{User-agent}i"
-	AgentLog agentLog = "%s\n"
-)
-
-type (
-	commonLog          string
-	commonLogWithVHost string
-	ncsaLog            string
-	refererLog         string
-	agentLog           string
-)
-
-// ApacheLogRecord is a structure containing the necessary information
-// to write a proper log in the ApacheFormatPattern.
+// ApacheLogRecord wraps an http.ResponseWriter to capture everything a
+// LogFormatter needs to render one access log entry. It's never handed
+// to the wrapped handler directly; wrapResponseWriter picks a type that
+// embeds it alongside exactly the optional interfaces (http.Flusher,
+// http.Hijacker, ...) the original ResponseWriter supports. Records are
+// recycled through Log's sync.Pool, so ServeHTTP must reset every field
+// before reuse; see (*Log).getRecord.
 type ApacheLogRecord struct {
 	http.ResponseWriter
-	Logger
-
-	ip            string
-	time          time.Time
-	method        string
-	uri           string
-	protocol      string
-	status        int
-	responseBytes int64
-	elapsedTime   time.Duration
-	referer       string
-	agent         string
-}
 
-// Hijack implements the http.Hijacker interface to allow connection
-// hijacking.
-func (a *ApacheLogRecord) Hijack() (rwc net.Conn, buf *bufio.ReadWriter, err error) {
-	hj, ok := a.ResponseWriter.(http.Hijacker)
-	if !ok {
-		return nil, nil, ErrUnHijackable
-	}
-	return hj.Hijack()
+	// Formatter renders this record. Set by Handler.ServeHTTP from the
+	// Handler's Options.Logger.
+	Formatter LogFormatter
+
+	request        *http.Request
+	responseHeader http.Header
+	clientIP       string
+	time           time.Time
+	status         int
+	responseBytes  int64
+	elapsedTime    time.Duration
 }
 
-// Log will log an entry to its io.Writer.
-func (l *Log) Log(r ApacheLogRecord) {
-	l.Lock()
-	n, err := r.WriteTo(l.out)
-	if err != nil {
-		return
+// params builds the LogFormatterParams passed to r.Formatter. It must
+// only read request/responseHeader, never r.ResponseWriter or a live
+// *http.Request, since by the time the writer goroutine calls this the
+// original ServeHTTP has long since returned; see snapshot.
+func (r *ApacheLogRecord) params() LogFormatterParams {
+	return LogFormatterParams{
+		Request:        r.request,
+		ClientIP:       r.clientIP,
+		TimeStamp:      r.time,
+		StatusCode:     r.status,
+		Size:           r.responseBytes,
+		Elapsed:        r.elapsedTime,
+		RequestHeader:  r.request.Header,
+		ResponseHeader: r.responseHeader,
 	}
-	if l.size+int64(n) >= l.MaxFileSize {
-		l.Rotate()
-	}
-	l.size += int64(n)
-	l.Unlock()
 }
 
-func (r ApacheLogRecord) WriteTo(w io.Writer) (n int64, err error) {
-	nn, err := r.Logger.WriteLog(w, r)
-	return int64(nn), err
+// snapshot freezes everything Formatter might read off of r.request and
+// r.ResponseWriter. It must be called synchronously from ServeHTTP,
+// before the record is handed to enqueue: per http.Handler's doc
+// comment, "it is not valid to use the ResponseWriter or read from the
+// Request ... after ... the completion of the ServeHTTP call," but
+// rendering happens later, on the writer goroutine.
+func (r *ApacheLogRecord) snapshot() {
+	r.request = r.request.Clone(r.request.Context())
+	r.responseHeader = r.ResponseWriter.Header().Clone()
 }
 
 // Write fulfills the Write method of the http.ResponseWriter interface.
@@ -106,31 +72,20 @@ func (r *ApacheLogRecord) WriteHeader(status int) {
 
 // ServeHTTP fulfills the ServeHTTP method of the http.Handler interface.
 func (h *Handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	clientIP := r.RemoteAddr
-	if colon := strings.LastIndex(clientIP, ":"); colon != -1 {
-		clientIP = clientIP[:colon]
-	}
-
-	record := ApacheLogRecord{
-		ResponseWriter: rw,
-		Logger:         h.Log,
-		ip:             clientIP,
-		time:           time.Time{},
-		method:         r.Method,
-		uri:            r.RequestURI,
-		protocol:       r.Proto,
-		status:         http.StatusOK,
-		elapsedTime:    time.Duration(0),
-		referer:        r.Referer(),
-		agent:          r.UserAgent(),
-	}
+	record := h.Log.getRecord()
+	record.ResponseWriter = rw
+	record.Formatter = h.Log.opts.Logger
+	record.request = r
+	record.clientIP = h.Log.resolveClientIP(r)
+	record.status = http.StatusOK
 
 	startTime := time.Now()
-	h.handler.ServeHTTP(&record, r)
+	h.handler.ServeHTTP(wrapResponseWriter(record), r)
 	finishTime := time.Now()
 
 	record.time = finishTime.UTC()
 	record.elapsedTime = finishTime.Sub(startTime)
+	record.snapshot()
 
-	h.Log.Log(record)
+	h.Log.enqueue(record)
 }
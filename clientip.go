@@ -0,0 +1,84 @@
+package useful
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies parses o.TrustedProxies into IPNets, reporting
+// any unparsable entry via o.ErrorHandler and skipping it.
+func parseTrustedProxies(o Options) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range o.TrustedProxies {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			o.ErrorHandler(fmt.Errorf("useful: invalid TrustedProxies entry %q: %w", cidr, err))
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// peerHost returns the request's direct peer address with any port
+// stripped, falling back to the raw RemoteAddr if it can't be split
+// (e.g. it's already bare, as in tests).
+func peerHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ipTrusted reports whether host parses as an IP falling inside one of
+// trusted.
+func ipTrusted(host string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClientIP returns the request's real client address. If the
+// direct peer isn't in l.trustedProxies, it's returned as-is; forwarding
+// headers are never trusted from an untrusted peer, since they're
+// trivially spoofable. Otherwise, each header in l.opts.ForwardedHeaders
+// is walked right to left (the order a chain of proxies appends in),
+// skipping entries that are themselves trusted proxies, and the first
+// untrusted entry found is returned as the real client address. If
+// every entry turns out to be trusted, or no header yields one, the
+// direct peer is returned.
+func (l *Log) resolveClientIP(r *http.Request) string {
+	peer := peerHost(r)
+
+	if len(l.trustedProxies) == 0 || !ipTrusted(peer, l.trustedProxies) {
+		return peer
+	}
+
+	for _, header := range l.opts.ForwardedHeaders {
+		v := r.Header.Get(header)
+		if v == "" {
+			continue
+		}
+
+		hops := strings.Split(v, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" || ipTrusted(hop, l.trustedProxies) {
+				continue
+			}
+			return hop
+		}
+	}
+
+	return peer
+}
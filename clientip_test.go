@@ -0,0 +1,91 @@
+package useful
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newResolveLog(trustedProxies []string) *Log {
+	var o Options
+	o.TrustedProxies = trustedProxies
+	o.ErrorHandler = func(error) {}
+	o.applyDefaults()
+
+	return &Log{
+		opts:           o,
+		trustedProxies: parseTrustedProxies(o),
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		xff            string
+		want           string
+	}{
+		{
+			name:           "untrusted peer ignores XFF",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "203.0.113.5:1234",
+			xff:            "198.51.100.7",
+			want:           "203.0.113.5",
+		},
+		{
+			name:           "trusted peer picks rightmost untrusted hop",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.1:1234",
+			xff:            "198.51.100.7, 198.51.100.8, 10.0.0.1",
+			want:           "198.51.100.8",
+		},
+		{
+			name:           "all hops trusted falls back to peer",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.1:1234",
+			xff:            "10.0.0.2, 10.0.0.1",
+			want:           "10.0.0.1",
+		},
+		{
+			name:           "no forwarding header falls back to peer",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.1:1234",
+			xff:            "",
+			want:           "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newResolveLog(tt.trustedProxies)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+
+			if got := l.resolveClientIP(r); got != tt.want {
+				t.Errorf("resolveClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesSkipsMalformedCIDR(t *testing.T) {
+	var errs []error
+	o := Options{
+		TrustedProxies: []string{"10.0.0.0/8", "not-a-cidr", "192.168.0.0/16"},
+		ErrorHandler:   func(err error) { errs = append(errs, err) },
+	}
+
+	nets := parseTrustedProxies(o)
+
+	if len(nets) != 2 {
+		t.Fatalf("parseTrustedProxies() returned %d nets, want 2", len(nets))
+	}
+	if len(errs) != 1 {
+		t.Fatalf("parseTrustedProxies() reported %d errors, want 1", len(errs))
+	}
+}
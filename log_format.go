@@ -1,43 +1,357 @@
 package useful
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// Logger is the interface implemented by log types to print an
-// ApacheLogRecord in the desired format.
-type Logger interface {
-	WriteLog(w io.Writer, r ApacheLogRecord) (n int, err error)
+// LogFormatterParams is the set of fields made available to a
+// LogFormatter when it's asked to render a single access log entry.
+type LogFormatterParams struct {
+	// Request is the incoming request. Formatters must treat it as
+	// read-only.
+	Request *http.Request
+
+	// ClientIP is the resolved real client address: Request.RemoteAddr
+	// with the port stripped, or a proxy-forwarded address if the peer
+	// is a trusted proxy. See Options.TrustedProxies.
+	ClientIP string
+
+	// TimeStamp is when the request finished being handled.
+	TimeStamp time.Time
+
+	// StatusCode is the status code written to the response, or
+	// http.StatusOK if WriteHeader was never called.
+	StatusCode int
+
+	// Size is the number of bytes written to the response body.
+	Size int64
+
+	// Elapsed is how long the handler took to serve the request.
+	Elapsed time.Duration
+
+	// RequestHeader and ResponseHeader are captured so that formatters
+	// can expose directives like %{X-Request-ID}i and %{Content-Type}o.
+	RequestHeader  http.Header
+	ResponseHeader http.Header
 }
 
-const timeFormat = "02/Jan/2006 03:04:05"
+// apacheTimeFormat is the timestamp format used by %t, e.g.
+// "26/Jul/2026:15:04:05 -0700".
+const apacheTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// directive is one compiled piece of an Apache-style log format: either
+// a literal run of bytes or a function that writes a single field from
+// a LogFormatterParams.
+type directive func(w io.Writer, p LogFormatterParams)
+
+// LogFormatter renders one LogFormatterParams to w. Implementations
+// must not retain w, or any Request/Header values in p, past the call.
+type LogFormatter func(w io.Writer, p LogFormatterParams)
+
+// Apache mod_log_config format strings.
+// See https://httpd.apache.org/docs/2.2/mod/mod_log_config.html.
+const (
+	// CommonLogFormat is "%h %l %u %t \"%r\" %>s %b".
+	CommonLogFormat = `%h %l %u %t "%r" %>s %b` + "\n"
+
+	// CombinedLogFormat is CommonLogFormat plus the Referer and
+	// User-agent request headers, i.e. NCSA's "combined" format.
+	CombinedLogFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"` + "\n"
+)
 
-// timeRequest returns the formatted time of the request and the request line.
-func (r ApacheLogRecord) formattedTimeRequest() (string, string) {
-	return r.time.Format(timeFormat), strings.Join([]string{r.method, r.uri, r.protocol}, " ")
+var (
+	// ApacheCommonLog is CommonLogFormat, compiled and ready to use as
+	// Options.Logger.
+	ApacheCommonLog = mustApacheLogFormatter(CommonLogFormat)
+
+	// ApacheCombinedLog is CombinedLogFormat, compiled and ready to use
+	// as Options.Logger.
+	ApacheCombinedLog = mustApacheLogFormatter(CombinedLogFormat)
+)
+
+func mustApacheLogFormatter(format string) LogFormatter {
+	f, err := NewApacheLogFormatter(format)
+	if err != nil {
+		panic(err)
+	}
+	return f
 }
 
-func (l commonLog) WriteLog(w io.Writer, r ApacheLogRecord) (n int, err error) {
-	timeFormatted, requestLine := r.formattedTimeRequest()
-	return fmt.Fprintf(w, string(l), r.ip, timeFormatted, requestLine, r.status, r.responseBytes)
+// NewApacheLogFormatter compiles a mod_log_config-style format string,
+// such as CommonLogFormat or CombinedLogFormat, into a LogFormatter.
+// The string is parsed once, at construction time, into a slice of
+// writer funcs so that formatting a request never touches fmt's
+// reflection machinery.
+func NewApacheLogFormatter(format string) (LogFormatter, error) {
+	directives, err := compileApacheFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	return func(w io.Writer, p LogFormatterParams) {
+		for _, d := range directives {
+			d(w, p)
+		}
+	}, nil
 }
 
-func (l commonLogWithVHost) WriteLog(w io.Writer, r ApacheLogRecord) (n int, err error) {
-	timeFormatted, requestLine := r.formattedTimeRequest()
-	return fmt.Fprintf(w, string(l), r.ip, timeFormatted, requestLine, r.status, r.responseBytes)
+// compileApacheFormat parses format into a slice of directives.
+func compileApacheFormat(format string) ([]directive, error) {
+	var directives []directive
+	var lit strings.Builder
+
+	flushLit := func() {
+		if lit.Len() == 0 {
+			return
+		}
+		s := lit.String()
+		directives = append(directives, func(w io.Writer, _ LogFormatterParams) {
+			io.WriteString(w, s)
+		})
+		lit.Reset()
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+
+		switch {
+		case c == '\\' && i+1 < len(format):
+			i++
+			lit.WriteByte(format[i])
+
+		case c != '%':
+			lit.WriteByte(c)
+
+		default:
+			i++
+			if i >= len(format) {
+				return nil, fmt.Errorf("useful: dangling %% at end of format %q", format)
+			}
+
+			// "%%" is a literal percent.
+			if format[i] == '%' {
+				lit.WriteByte('%')
+				continue
+			}
+
+			// "%>s" means "final status", which is the only status we
+			// ever have to report, so the modifier is accepted and
+			// discarded.
+			if format[i] == '>' {
+				i++
+				if i >= len(format) {
+					return nil, fmt.Errorf("useful: dangling %%> at end of format %q", format)
+				}
+			}
+
+			if format[i] == '{' {
+				end := strings.IndexByte(format[i:], '}')
+				if end == -1 {
+					return nil, fmt.Errorf("useful: unterminated %%{ in format %q", format)
+				}
+				name := format[i+1 : i+end]
+				i += end + 1
+				if i >= len(format) {
+					return nil, fmt.Errorf("useful: %%{%s} missing type in format %q", name, format)
+				}
+
+				flushLit()
+				var d directive
+				switch format[i] {
+				case 'i':
+					d = headerDirective(name)
+				case 'o':
+					d = respHeaderDirective(name)
+				case 'C':
+					d = cookieDirective(name)
+				case 'x':
+					d = tlsVarDirective(name)
+				default:
+					return nil, fmt.Errorf("useful: unsupported %%{%s}%c in format %q", name, format[i], format)
+				}
+				directives = append(directives, d)
+				continue
+			}
+
+			flushLit()
+			d, err := apacheDirective(format[i])
+			if err != nil {
+				return nil, fmt.Errorf("useful: %s in format %q", err, format)
+			}
+			directives = append(directives, d)
+		}
+	}
+	flushLit()
+
+	return directives, nil
+}
+
+// apacheDirective returns the directive for a single-character %
+// directive, e.g. 'h' for %h.
+func apacheDirective(c byte) (directive, error) {
+	switch c {
+	case 'h':
+		return func(w io.Writer, p LogFormatterParams) {
+			io.WriteString(w, p.ClientIP)
+		}, nil
+	case 'l':
+		return func(w io.Writer, _ LogFormatterParams) {
+			io.WriteString(w, "-")
+		}, nil
+	case 'u':
+		return func(w io.Writer, p LogFormatterParams) {
+			if u, _, ok := p.Request.BasicAuth(); ok {
+				io.WriteString(w, u)
+				return
+			}
+			io.WriteString(w, "-")
+		}, nil
+	case 't':
+		return func(w io.Writer, p LogFormatterParams) {
+			io.WriteString(w, "["+p.TimeStamp.Format(apacheTimeFormat)+"]")
+		}, nil
+	case 'r':
+		return func(w io.Writer, p LogFormatterParams) {
+			io.WriteString(w, p.Request.Method+" "+p.Request.RequestURI+" "+p.Request.Proto)
+		}, nil
+	case 's':
+		return func(w io.Writer, p LogFormatterParams) {
+			io.WriteString(w, strconv.Itoa(p.StatusCode))
+		}, nil
+	case 'b':
+		return func(w io.Writer, p LogFormatterParams) {
+			if p.Size == 0 {
+				io.WriteString(w, "-")
+				return
+			}
+			io.WriteString(w, strconv.FormatInt(p.Size, 10))
+		}, nil
+	case 'D':
+		return func(w io.Writer, p LogFormatterParams) {
+			io.WriteString(w, strconv.FormatInt(p.Elapsed.Microseconds(), 10))
+		}, nil
+	case 'v':
+		return func(w io.Writer, p LogFormatterParams) {
+			io.WriteString(w, p.Request.Host)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported directive %%%c", c)
+	}
+}
+
+func headerDirective(name string) directive {
+	return func(w io.Writer, p LogFormatterParams) {
+		writeHeaderValue(w, p.RequestHeader, name)
+	}
+}
+
+func respHeaderDirective(name string) directive {
+	return func(w io.Writer, p LogFormatterParams) {
+		writeHeaderValue(w, p.ResponseHeader, name)
+	}
 }
 
-func (l ncsaLog) WriteLog(w io.Writer, r ApacheLogRecord) (n int, err error) {
-	timeFormatted, requestLine := r.formattedTimeRequest()
-	return fmt.Fprintf(w, string(l), r.ip, timeFormatted, requestLine, r.status, r.responseBytes, r.referer, r.agent)
+func writeHeaderValue(w io.Writer, h http.Header, name string) {
+	if v := h.Get(name); v != "" {
+		io.WriteString(w, v)
+		return
+	}
+	io.WriteString(w, "-")
 }
 
-func (l refererLog) WriteLog(w io.Writer, r ApacheLogRecord) (n int, err error) {
-	return fmt.Fprintf(w, string(l), r.referer, r.uri)
+func cookieDirective(name string) directive {
+	return func(w io.Writer, p LogFormatterParams) {
+		c, err := p.Request.Cookie(name)
+		if err != nil {
+			io.WriteString(w, "-")
+			return
+		}
+		io.WriteString(w, c.Value)
+	}
 }
 
-func (l agentLog) WriteLog(w io.Writer, r ApacheLogRecord) (n int, err error) {
-	return fmt.Fprintf(w, string(l), r.agent)
+// tlsVarDirective implements %{name}x for the small set of mod_ssl-style
+// variables a request's tls.ConnectionState can answer.
+func tlsVarDirective(name string) directive {
+	return func(w io.Writer, p LogFormatterParams) {
+		io.WriteString(w, tlsVar(p.Request, name))
+	}
+}
+
+func tlsVar(r *http.Request, name string) string {
+	if r.TLS == nil {
+		return "-"
+	}
+	switch name {
+	case "SSL_PROTOCOL":
+		return tls.VersionName(r.TLS.Version)
+	case "SSL_CIPHER":
+		return tls.CipherSuiteName(r.TLS.CipherSuite)
+	case "SSL_SERVER_NAME":
+		return r.TLS.ServerName
+	default:
+		return "-"
+	}
+}
+
+// jsonLogEntry is the shape written by JSONLog.
+type jsonLogEntry struct {
+	Host      string    `json:"host"`
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Size      int64     `json:"size"`
+	ElapsedMs float64   `json:"elapsed_ms"`
+	Referer   string    `json:"referer,omitempty"`
+	Agent     string    `json:"agent,omitempty"`
+}
+
+// JSONLog formats each entry as a single line of JSON, suitable for
+// ingestion by log shippers like Logstash or Fluentd.
+func JSONLog(w io.Writer, p LogFormatterParams) {
+	entry := jsonLogEntry{
+		Host:      p.ClientIP,
+		Time:      p.TimeStamp,
+		Method:    p.Request.Method,
+		URI:       p.Request.RequestURI,
+		Protocol:  p.Request.Proto,
+		Status:    p.StatusCode,
+		Size:      p.Size,
+		ElapsedMs: float64(p.Elapsed) / float64(time.Millisecond),
+		Referer:   p.Request.Referer(),
+		Agent:     p.Request.UserAgent(),
+	}
+
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		fmt.Fprintf(w, `{"error":%q}`+"\n", err.Error())
+	}
+}
+
+// W3CExtendedFields is the "#Fields:" directive line matching the field
+// order W3CExtendedLog writes. Callers that want a self-describing log
+// file should write it once, before the first entry.
+const W3CExtendedFields = "#Fields: date time c-ip cs-method cs-uri-stem sc-status sc-bytes time-taken\n"
+
+// W3CExtendedLog formats each entry per the W3C Extended Log File
+// Format (https://www.w3.org/TR/WD-logfile.html), using the field order
+// declared in W3CExtendedFields.
+func W3CExtendedLog(w io.Writer, p LogFormatterParams) {
+	fmt.Fprintf(w, "%s %s %s %s %s %d %d %d\n",
+		p.TimeStamp.UTC().Format("2006-01-02"),
+		p.TimeStamp.UTC().Format("15:04:05"),
+		p.ClientIP,
+		p.Request.Method,
+		p.Request.URL.Path,
+		p.StatusCode,
+		p.Size,
+		p.Elapsed/time.Millisecond,
+	)
 }
@@ -0,0 +1,147 @@
+package useful
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testParams() LogFormatterParams {
+	r := httptest.NewRequest(http.MethodGet, "/path?q=1", nil)
+	r.Header.Set("Referer", "http://example.com/")
+	r.Header.Set("X-Request-Id", "abc123")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "xyz"})
+
+	return LogFormatterParams{
+		Request:        r,
+		ClientIP:       "203.0.113.5",
+		TimeStamp:      time.Date(2026, time.July, 26, 15, 4, 5, 0, time.UTC),
+		StatusCode:     http.StatusTeapot,
+		Size:           42,
+		Elapsed:        1500 * time.Microsecond,
+		RequestHeader:  r.Header,
+		ResponseHeader: http.Header{"Content-Type": []string{"text/plain"}},
+	}
+}
+
+func render(t *testing.T, format string, p LogFormatterParams) string {
+	t.Helper()
+
+	f, err := NewApacheLogFormatter(format)
+	if err != nil {
+		t.Fatalf("NewApacheLogFormatter(%q) error = %v", format, err)
+	}
+
+	var buf bytes.Buffer
+	f(&buf, p)
+	return buf.String()
+}
+
+func TestCompileApacheFormatDirectives(t *testing.T) {
+	p := testParams()
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{"literal text", "hello world", "hello world"},
+		{"escaped percent via backslash", `100\%`, "100%"},
+		{"literal percent via %%", "100%%", "100%"},
+		{"client IP", "%h", "203.0.113.5"},
+		{"remote logname always dash", "%l", "-"},
+		{"unauthenticated user always dash", "%u", "-"},
+		{"timestamp", "%t", "[26/Jul/2026:15:04:05 +0000]"},
+		{"request line", "%r", "GET /path?q=1 HTTP/1.1"},
+		{"status", "%s", "418"},
+		{"final status modifier", "%>s", "418"},
+		{"response size", "%b", "42"},
+		{"response size zero is dash", "%b", "-"},
+		{"elapsed microseconds", "%D", "1500"},
+		{"request host", "%v", "example.com"},
+		{"request header", "%{X-Request-Id}i", "abc123"},
+		{"missing request header is dash", "%{Nope}i", "-"},
+		{"response header", "%{Content-Type}o", "text/plain"},
+		{"cookie", "%{session}C", "xyz"},
+		{"missing cookie is dash", "%{nope}C", "-"},
+		{"tls var without TLS is dash", "%{SSL_PROTOCOL}x", "-"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := p
+			if tt.name == "response size zero is dash" {
+				pp.Size = 0
+			}
+			if got := render(t, tt.format, pp); got != tt.want {
+				t.Errorf("render(%q) = %q, want %q", tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileApacheFormatCommonAndCombined(t *testing.T) {
+	p := testParams()
+	p.Request.Header.Set("User-agent", "test-agent")
+
+	common := render(t, CommonLogFormat, p)
+	wantCommon := `203.0.113.5 - - [26/Jul/2026:15:04:05 +0000] "GET /path?q=1 HTTP/1.1" 418 42` + "\n"
+	if common != wantCommon {
+		t.Errorf("CommonLogFormat rendered = %q, want %q", common, wantCommon)
+	}
+
+	combined := render(t, CombinedLogFormat, p)
+	if !strings.HasSuffix(combined, `"http://example.com/" "test-agent"`+"\n") {
+		t.Errorf("CombinedLogFormat rendered = %q, missing referer/agent suffix", combined)
+	}
+}
+
+func TestCompileApacheFormatErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{"dangling percent", "abc%"},
+		{"dangling percent-greater-than", "abc%>"},
+		{"unterminated brace", "abc%{name"},
+		{"unsupported single-char directive", "%Q"},
+		{"unsupported brace type", "%{name}Q"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileApacheFormat(tt.format); err == nil {
+				t.Errorf("compileApacheFormat(%q) error = nil, want non-nil", tt.format)
+			}
+		})
+	}
+}
+
+func TestJSONLog(t *testing.T) {
+	p := testParams()
+
+	var buf bytes.Buffer
+	JSONLog(&buf, p)
+
+	out := buf.String()
+	for _, want := range []string{`"host":"203.0.113.5"`, `"status":418`, `"size":42`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("JSONLog output %q missing %q", out, want)
+		}
+	}
+}
+
+func TestW3CExtendedLog(t *testing.T) {
+	p := testParams()
+
+	var buf bytes.Buffer
+	W3CExtendedLog(&buf, p)
+
+	want := "2026-07-26 15:04:05 203.0.113.5 GET /path 418 42 1\n"
+	if buf.String() != want {
+		t.Errorf("W3CExtendedLog rendered = %q, want %q", buf.String(), want)
+	}
+}
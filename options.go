@@ -0,0 +1,110 @@
+package useful
+
+import (
+	"log"
+	"time"
+)
+
+// Options configures a Handler/Log. Any zero-valued field falls back to
+// its package-level default (ApacheCommonLog, a stdout+file Sink pair,
+// "access.log", "archives", 1*Gigabyte).
+type Options struct {
+	// Logger formats each access log entry. Defaults to ApacheCommonLog.
+	Logger LogFormatter
+
+	// Sinks are the destinations each rendered entry is fanned out to.
+	// Defaults to NewStdoutSink() plus a NewFileSink built from LogName,
+	// ArchiveDir, and Compress, matching the behavior of writing to
+	// stdout and a local file. Set it to choose different destinations,
+	// e.g. []Sink{sink} to write only to syslog.
+	Sinks []Sink
+
+	// LogName is the path of the active log file. Only used to build the
+	// default file Sink when Sinks is nil. Defaults to "access.log".
+	LogName string
+
+	// ArchiveDir is the directory rotated logs are compressed into. Only
+	// used to build the default file Sink when Sinks is nil. Defaults to
+	// "archives".
+	ArchiveDir string
+
+	// MaxFileSize is the size, in bytes, a log file may reach before
+	// it's rotated. Defaults to 1 Gigabyte.
+	MaxFileSize int64
+
+	// RotateInterval, if set, rotates the log on a fixed schedule (e.g.
+	// 24*time.Hour for daily) in addition to the MaxFileSize trigger.
+	// Zero disables time-based rotation.
+	RotateInterval time.Duration
+
+	// MaxBackups is the number of archives to retain; the oldest are
+	// deleted first. Zero disables count-based retention.
+	MaxBackups int
+
+	// MaxAge is the maximum age an archive may reach before it's
+	// deleted. Zero disables age-based retention.
+	MaxAge time.Duration
+
+	// Compress selects whether the default file Sink's rotated logs are
+	// gzip-compressed before being moved into ArchiveDir. Only used to
+	// build the default file Sink when Sinks is nil. Defaults to false.
+	Compress bool
+
+	// LocalTime selects local time, rather than UTC, when comparing an
+	// archive's age against MaxAge. Defaults to false (UTC).
+	LocalTime bool
+
+	// ErrorHandler is called with any error encountered while writing
+	// or rotating the log, in place of failing the request. Defaults to
+	// logging the error via the standard library's log package.
+	ErrorHandler func(error)
+
+	// TrustedProxies lists, as CIDRs, the reverse proxies allowed to
+	// report a client's address on its behalf via ForwardedHeaders. A
+	// connecting peer only has its forwarding headers honored if its
+	// own address falls inside one of these ranges; otherwise the
+	// directly connected peer address is used. Defaults to none, i.e.
+	// ForwardedHeaders are never consulted.
+	TrustedProxies []string
+
+	// ForwardedHeaders lists, in the order they're tried, the headers
+	// consulted to find the real client address once the direct peer
+	// is found in TrustedProxies. Defaults to []string{"X-Forwarded-For"}.
+	ForwardedHeaders []string
+
+	// QueueSize is the number of records that may be buffered between
+	// ServeHTTP and the writer goroutine before BlockOnFull takes effect.
+	// Defaults to 1024.
+	QueueSize int
+
+	// BlockOnFull selects what happens when the queue is full: if true,
+	// ServeHTTP blocks until a slot frees up; if false, the record is
+	// dropped and counted in Log.Dropped. Defaults to false.
+	BlockOnFull bool
+}
+
+// applyDefaults fills in the package-level default for every zero-valued
+// field of o.
+func (o *Options) applyDefaults() {
+	if o.Logger == nil {
+		o.Logger = LogFormat
+	}
+	if o.LogName == "" {
+		o.LogName = "access.log"
+	}
+	if o.ArchiveDir == "" {
+		o.ArchiveDir = "archives"
+	}
+	if o.MaxFileSize == 0 {
+		o.MaxFileSize = 1 * Gigabyte
+	}
+	if o.ErrorHandler == nil {
+		o.ErrorHandler = func(err error) { log.Print(err) }
+	}
+	if o.ForwardedHeaders == nil {
+		o.ForwardedHeaders = []string{"X-Forwarded-For"}
+	}
+	if o.QueueSize == 0 {
+		o.QueueSize = 1024
+	}
+}
@@ -0,0 +1,105 @@
+package useful
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// getRecord returns an *ApacheLogRecord from l.recordPool, zeroed and
+// ready for ServeHTTP to populate.
+func (l *Log) getRecord() *ApacheLogRecord {
+	if r, ok := l.recordPool.Get().(*ApacheLogRecord); ok {
+		*r = ApacheLogRecord{}
+		return r
+	}
+	return &ApacheLogRecord{}
+}
+
+// putRecord returns r to l.recordPool.
+func (l *Log) putRecord(r *ApacheLogRecord) {
+	l.recordPool.Put(r)
+}
+
+// getBuf returns a *bytes.Buffer from l.bufPool, reset to empty.
+func (l *Log) getBuf() *bytes.Buffer {
+	if b, ok := l.bufPool.Get().(*bytes.Buffer); ok {
+		b.Reset()
+		return b
+	}
+	return new(bytes.Buffer)
+}
+
+// putBuf returns buf to l.bufPool.
+func (l *Log) putBuf(buf *bytes.Buffer) {
+	l.bufPool.Put(buf)
+}
+
+// enqueue hands r off to writeLoop. If l.records is full, enqueue blocks
+// when l.opts.BlockOnFull is set; otherwise it drops r and counts it in
+// l.dropped. It's safe to call concurrently with Close: closeMu makes
+// sure enqueue never sends on l.records after Close has closed it,
+// dropping r instead.
+func (l *Log) enqueue(r *ApacheLogRecord) {
+	l.closeMu.RLock()
+	defer l.closeMu.RUnlock()
+
+	if l.closed {
+		l.putRecord(r)
+		return
+	}
+
+	if l.opts.BlockOnFull {
+		l.records <- r
+		return
+	}
+
+	select {
+	case l.records <- r:
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+		l.putRecord(r)
+	}
+}
+
+// Dropped reports how many records have been dropped because l.records
+// was full. It's only meaningful when Options.BlockOnFull is false.
+func (l *Log) Dropped() int64 {
+	return atomic.LoadInt64(&l.dropped)
+}
+
+// writeLoop renders and writes every record sent to l.records, in order,
+// until l.records is closed by Close.
+func (l *Log) writeLoop() {
+	defer close(l.writeDone)
+
+	for r := range l.records {
+		l.writeRecord(r)
+		l.putRecord(r)
+	}
+}
+
+// writeRecord renders r via its Formatter and writes the result to l.out,
+// rotating the log afterward if it's grown past l.opts.MaxFileSize.
+func (l *Log) writeRecord(r *ApacheLogRecord) {
+	buf := l.getBuf()
+	r.Formatter(buf, r.params())
+
+	l.Lock()
+	n, err := l.out.Write(buf.Bytes())
+	l.size += int64(n)
+	rotate := l.size >= l.opts.MaxFileSize
+	l.Unlock()
+
+	l.putBuf(buf)
+
+	if err != nil {
+		l.opts.ErrorHandler(err)
+		return
+	}
+
+	if rotate {
+		if err := l.Rotate(); err != nil {
+			l.opts.ErrorHandler(err)
+		}
+	}
+}
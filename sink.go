@@ -0,0 +1,512 @@
+package useful
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is a destination Log writes rendered access log entries into. Log
+// fans every entry out to all configured sinks via io.MultiWriter, so
+// Write must be safe to call from the writer goroutine at any time.
+type Sink interface {
+	io.Writer
+
+	// Rotate is called by Log.Rotate, which runs when the active log has
+	// grown past Options.MaxFileSize, when a RotateInterval has elapsed,
+	// or when a caller invokes it directly.
+	// Sinks without a notion of rotation, such as a Stdout or syslog
+	// sink, should simply return nil.
+	Rotate() error
+
+	// Close releases any resources held by the sink. Called once, by
+	// Log.Close.
+	Close() error
+}
+
+// sizer is implemented by sinks that can report how many bytes they've
+// already accumulated, so that NewLog can resume MaxFileSize tracking
+// against an existing destination rather than starting from zero.
+type sizer interface {
+	Size() (int64, error)
+}
+
+// retainer is implemented by sinks that keep rotated archives around and
+// so need retention enforced against them. Log.enforceRetention calls it
+// on every configured sink that implements it.
+type retainer interface {
+	enforceRetention(maxBackups int, maxAge time.Duration, localTime bool, errorHandler func(error))
+}
+
+// defaultSinks builds the sinks used when Options.Sinks is left nil: the
+// same stdout-and-file combination Log wrote to before Sinks existed.
+func defaultSinks(o Options) ([]Sink, error) {
+	file, err := NewFileSink(o.LogName, o.ArchiveDir, o.Compress)
+	if err != nil {
+		return nil, err
+	}
+	return []Sink{NewStdoutSink(), file}, nil
+}
+
+// archPrefix is the temporary archive file's prefix before randName
+// appends a random string of digits to the end.
+const archPrefix = "._archive"
+
+// fileSink is the default Sink: it writes to a local file, and on
+// Rotate moves the current file into archiveDir (optionally
+// gzip-compressed) and opens a fresh one in its place.
+type fileSink struct {
+	name       string
+	archiveDir string
+	compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	pool *randPool
+	cur  int64
+}
+
+// NewFileSink returns a Sink that writes to name, rotating rename+gzip
+// archives of it into archiveDir. If name doesn't exist yet it's
+// created; otherwise the existing file is appended to.
+func NewFileSink(name, archiveDir string, compress bool) (Sink, error) {
+	file, err := newFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{
+		name:       name,
+		archiveDir: archiveDir,
+		compress:   compress,
+		file:       file,
+		pool:       newRandPool(25),
+		cur:        findCur(archiveDir),
+	}, nil
+}
+
+func (s *fileSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Write(p)
+}
+
+// Rotate moves the active file into s.archiveDir (compressed, if
+// s.compress is set) and opens a new one in its place. Because cur, the
+// file, and the archive directory are all fields of s, two fileSinks
+// rotating independently (even if one writes into the other's archive
+// directory) don't race with each other. Every failure is returned
+// rather than panicking, so a full disk or a permissions error degrades
+// gracefully instead of taking the server down.
+func (s *fileSink) Rotate() error {
+	s.mu.Lock()
+
+	// For speed.
+	randName := s.pool.get()
+
+	// Rename so we can release our lock on the file asap.
+	if err := os.Rename(s.name, randName); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("useful: rename %s: %w", s.name, err)
+	}
+
+	// Replace our physical file.
+	file, err := newFile(s.name)
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("useful: open %s: %w", s.name, err)
+	}
+	s.file = file
+
+	// E.g., "access.log#0000000001_.gz". We throw in the underscore
+	// before the extension to try to help identify our numbering scheme
+	// even if the user picks a wacky file name that includes numbers
+	// and stuff. s.name may itself contain a directory component (e.g.
+	// "/var/log/app/access.log"), so only its base name is joined onto
+	// archiveDir -- archiveDir is the only directory archiveLog creates.
+	path := filepath.Join(s.archiveDir, filepath.Base(s.name))
+	archiveName := fmt.Sprintf("%s#%010d_", path, s.cur)
+	if s.compress {
+		archiveName += ".gz"
+	}
+	s.cur++
+
+	s.mu.Unlock()
+
+	// Place the used name back into the pool for future use.
+	s.pool.put(randName)
+
+	// From here on out we don't need to worry about the active file,
+	// because we've already moved it and opened a new, unlocked one in
+	// its place.
+	return archiveLog(randName, archiveName, s.archiveDir, s.compress)
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// Size reports the active file's current size.
+func (s *fileSink) Size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := s.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// enforceRetention deletes archives in s.archiveDir beyond maxBackups
+// and/or older than maxAge. Either left at zero disables that check.
+// Errors are reported via errorHandler rather than returned, since this
+// runs on Log's background goroutine.
+func (s *fileSink) enforceRetention(maxBackups int, maxAge time.Duration, localTime bool, errorHandler func(error)) {
+	if maxAge <= 0 && maxBackups <= 0 {
+		return
+	}
+
+	dir, err := os.Open(s.archiveDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			errorHandler(fmt.Errorf("useful: open archive dir %s: %w", s.archiveDir, err))
+		}
+		return
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		errorHandler(fmt.Errorf("useful: read archive dir %s: %w", s.archiveDir, err))
+		return
+	}
+
+	prefix := filepath.Base(s.name) + "#"
+	var archives []string
+	for _, n := range names {
+		if strings.HasPrefix(n, prefix) {
+			archives = append(archives, n)
+		}
+	}
+
+	// Our naming scheme zero-pads the numeric suffix, so a lexical sort
+	// is also an age sort, oldest first.
+	sort.Strings(archives)
+
+	cutoff := 0
+	if maxBackups > 0 && len(archives) > maxBackups {
+		cutoff = len(archives) - maxBackups
+	}
+
+	now := time.Now()
+	if !localTime {
+		now = now.UTC()
+	}
+
+	for i, name := range archives {
+		path := filepath.Join(s.archiveDir, name)
+		remove := i < cutoff
+
+		if !remove && maxAge > 0 {
+			if info, err := os.Stat(path); err == nil && now.Sub(info.ModTime()) > maxAge {
+				remove = true
+			}
+		}
+
+		if remove {
+			if err := os.Remove(path); err != nil {
+				errorHandler(fmt.Errorf("useful: remove archive %s: %w", path, err))
+			}
+		}
+	}
+}
+
+// newFile returns a 'new' file to write logs to.
+// It's simply a wrapper around os.OpenFile.
+// While it says 'new', it'll return an already existing log file
+// if one exists.
+func newFile(name string) (file *os.File, err error) {
+	file, err = os.OpenFile(name,
+		os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	return
+}
+
+// findCur finds the next archive log number to use for the archive
+// directory dir: one past the highest existing archive's number. If dir
+// doesn't exist yet, or is empty, cur is 0.
+func findCur(dir string) int64 {
+	d, err := os.Open(dir)
+	if err != nil {
+		return 0
+	}
+	defer d.Close()
+
+	names, err := d.Readdirnames(-1)
+	if err != nil || len(names) == 0 {
+		return 0
+	}
+
+	// Sort the strings. Our naming scheme, "#%010d_", will allow us to
+	// select the last string in the slice once it's ordered
+	// in increasing order.
+	sort.Strings(names)
+
+	highest := names[len(names)-1]
+
+	// Our archives end in "#%010d_.gz" (compressed) or "#%010d_"
+	// (uncompressed).
+	if !strings.HasSuffix(highest, "_.gz") && !strings.HasSuffix(highest, "_") {
+		return 0
+	}
+
+	h := strings.LastIndex(highest, "#")
+	if h == -1 {
+		return 0
+	}
+
+	u := strings.LastIndex(highest, "_")
+	if u == -1 {
+		return 0
+	}
+
+	cur, err := strconv.ParseInt(highest[h+1:u], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return cur + 1
+}
+
+// archiveLog moves src, the just-rotated log, into archiveName inside
+// archiveDir, compressing it with gzip if compress is set.
+func archiveLog(src, archiveName, archiveDir string, compress bool) error {
+	if err := os.MkdirAll(archiveDir, 0700); err != nil {
+		return fmt.Errorf("useful: mkdir %s: %w", archiveDir, err)
+	}
+
+	if !compress {
+		if err := os.Rename(src, archiveName); err != nil {
+			return fmt.Errorf("useful: archive %s: %w", src, err)
+		}
+		return nil
+	}
+
+	archive, err := os.Create(archiveName)
+	if err != nil {
+		return fmt.Errorf("useful: create archive %s: %w", archiveName, err)
+	}
+	defer archive.Close()
+
+	oldLog, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("useful: open %s: %w", src, err)
+	}
+	defer oldLog.Close()
+
+	gzw, err := gzip.NewWriterLevel(archive, gzip.BestCompression)
+	if err != nil {
+		return fmt.Errorf("useful: gzip %s: %w", archiveName, err)
+	}
+
+	if _, err := io.Copy(gzw, oldLog); err != nil {
+		gzw.Close()
+		return fmt.Errorf("useful: compress %s: %w", src, err)
+	}
+	if err := gzw.Close(); err != nil {
+		return fmt.Errorf("useful: flush gzip %s: %w", archiveName, err)
+	}
+
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("useful: remove %s: %w", src, err)
+	}
+
+	return nil
+}
+
+// writerSink is a Sink around a plain io.Writer that has no file of its
+// own to rotate and shouldn't be closed by Log, such as os.Stdout.
+type writerSink struct {
+	w io.Writer
+}
+
+// NewStdoutSink returns a Sink that writes to os.Stdout. Rotate and
+// Close are both no-ops.
+func NewStdoutSink() Sink {
+	return writerSink{os.Stdout}
+}
+
+// NewStderrSink returns a Sink that writes to os.Stderr. Rotate and
+// Close are both no-ops.
+func NewStderrSink() Sink {
+	return writerSink{os.Stderr}
+}
+
+func (s writerSink) Write(p []byte) (int, error) { return s.w.Write(p) }
+func (s writerSink) Rotate() error               { return nil }
+func (s writerSink) Close() error                { return nil }
+
+// syslogSink is a Sink that forwards entries to a syslog daemon.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink dials the syslog daemon at raddr over network ("" for
+// both to log to the local syslog daemon instead) and returns a Sink
+// that writes each entry under the given facility/severity priority and
+// tag. Rotate is a no-op; the syslog daemon owns rotation of its own
+// logs.
+func NewSyslogSink(network, raddr string, priority syslog.Priority, tag string) (Sink, error) {
+	w, err := syslog.Dial(network, raddr, priority, tag)
+	if err != nil {
+		return nil, fmt.Errorf("useful: dial syslog: %w", err)
+	}
+	return &syslogSink{w}, nil
+}
+
+func (s *syslogSink) Write(p []byte) (int, error) { return s.w.Write(p) }
+func (s *syslogSink) Rotate() error               { return nil }
+func (s *syslogSink) Close() error                { return s.w.Close() }
+
+// funcSink is a Sink around a user-supplied factory, for destinations
+// Log has no built-in support for, e.g. an S3-compatible object store or
+// a network socket. Rotate closes the current io.WriteCloser and calls
+// factory again for a fresh one, so the factory itself decides what
+// "rotated" means for its destination.
+type funcSink struct {
+	factory func() (io.WriteCloser, error)
+
+	mu sync.Mutex
+	wc io.WriteCloser
+}
+
+// NewFuncSink calls factory for an initial io.WriteCloser and returns a
+// Sink that writes to it, calling factory again for a fresh one on every
+// Rotate.
+func NewFuncSink(factory func() (io.WriteCloser, error)) (Sink, error) {
+	wc, err := factory()
+	if err != nil {
+		return nil, err
+	}
+	return &funcSink{factory: factory, wc: wc}, nil
+}
+
+func (s *funcSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wc.Write(p)
+}
+
+func (s *funcSink) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wc.Close(); err != nil {
+		return fmt.Errorf("useful: close rotated sink: %w", err)
+	}
+	wc, err := s.factory()
+	if err != nil {
+		return fmt.Errorf("useful: open rotated sink: %w", err)
+	}
+	s.wc = wc
+	return nil
+}
+
+func (s *funcSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wc.Close()
+}
+
+// randPool is a pool of random names used for rotating log files.
+type randPool struct {
+	c chan string
+	*sync.Mutex
+}
+
+// newRandPool creates a new pool of random names and immediately
+// initializes the pool with N new names.
+func newRandPool(n int) *randPool {
+	pool := &randPool{
+		make(chan string, n),
+		&sync.Mutex{},
+	}
+
+	for i := 0; i < n; i++ {
+		pool.put(randName(archPrefix))
+	}
+
+	return pool
+}
+
+// get gets a name from the pool, or generates a new name if none
+// exist.
+func (p *randPool) get() (s string) {
+	p.Lock()
+	defer p.Unlock()
+
+	select {
+	case s = <-p.c:
+		// get a name from the pool
+	default:
+		return randName(archPrefix)
+	}
+	return
+}
+
+// put puts a new name (back) into the pool, or discards it if the pool
+// is full.
+func (p *randPool) put(s string) {
+	p.Lock()
+	defer p.Unlock()
+
+	select {
+	case p.c <- s:
+		// place back into pool
+	default:
+		// discard if pool is full
+	}
+}
+
+// Borrowed from https://golang.org/src/io/ioutil/tempfile.go#L19
+
+var rand uint32
+var randmu sync.Mutex
+
+func reseed() uint32 {
+	return uint32(time.Now().UnixNano() + int64(os.Getpid()))
+}
+
+func nextSuffix() string {
+	randmu.Lock()
+	r := rand
+	if r == 0 {
+		r = reseed()
+	}
+	r = r*1664525 + 1013904223 // constants from Numerical Recipes
+	rand = r
+	randmu.Unlock()
+	return strconv.Itoa(int(1e9 + r%1e9))[1:]
+}
+
+func randName(prefix string) (name string) {
+	nconflict := 0
+	for i := 0; i < 10000; i++ {
+		name = prefix + nextSuffix()
+		_, err := os.Stat(name)
+		if os.IsExist(err) {
+			if nconflict++; nconflict > 10 {
+				rand = reseed()
+			}
+			continue
+		}
+		break
+	}
+	return
+}
@@ -30,8 +30,7 @@ func main() {
 	})
 
 	opts := useful.Options{
-		Logger:      useful.NCSALog,
-		Destination: useful.Both,
+		Logger:      useful.ApacheCombinedLog,
 		ArchiveDir:  "archives",
 		LogName:     "access.log",
 		MaxFileSize: 2 * useful.Megabyte,
@@ -0,0 +1,336 @@
+// wrapResponseWriter and the types below it are hand-written, not
+// generated: http.ResponseWriter's optional interfaces (http.Flusher,
+// http.Hijacker, http.Pusher, http.CloseNotifier, io.ReaderFrom) can
+// only be asserted on a concrete type that actually declares them, so
+// every combination a wrapped ResponseWriter might support needs its own
+// struct. See wrap_test.go for coverage confirming the type assertions
+// behave the same before and after wrapping.
+
+package useful
+
+import (
+	"io"
+	"net/http"
+)
+
+// base is embedded in every wrapper below. It implements
+// http.ResponseWriter by delegating straight to the wrapped record,
+// which is where Write/WriteHeader actually account for responseBytes
+// and status.
+type base struct {
+	*ApacheLogRecord
+}
+
+type wrap struct {
+	base
+}
+
+type wrapFlusher struct {
+	base
+	http.Flusher
+}
+
+type wrapHijacker struct {
+	base
+	http.Hijacker
+}
+
+type wrapFlusherHijacker struct {
+	base
+	http.Flusher
+	http.Hijacker
+}
+
+type wrapPusher struct {
+	base
+	http.Pusher
+}
+
+type wrapFlusherPusher struct {
+	base
+	http.Flusher
+	http.Pusher
+}
+
+type wrapHijackerPusher struct {
+	base
+	http.Hijacker
+	http.Pusher
+}
+
+type wrapFlusherHijackerPusher struct {
+	base
+	http.Flusher
+	http.Hijacker
+	http.Pusher
+}
+
+type wrapCloseNotifier struct {
+	base
+	http.CloseNotifier
+}
+
+type wrapFlusherCloseNotifier struct {
+	base
+	http.Flusher
+	http.CloseNotifier
+}
+
+type wrapHijackerCloseNotifier struct {
+	base
+	http.Hijacker
+	http.CloseNotifier
+}
+
+type wrapFlusherHijackerCloseNotifier struct {
+	base
+	http.Flusher
+	http.Hijacker
+	http.CloseNotifier
+}
+
+type wrapPusherCloseNotifier struct {
+	base
+	http.Pusher
+	http.CloseNotifier
+}
+
+type wrapFlusherPusherCloseNotifier struct {
+	base
+	http.Flusher
+	http.Pusher
+	http.CloseNotifier
+}
+
+type wrapHijackerPusherCloseNotifier struct {
+	base
+	http.Hijacker
+	http.Pusher
+	http.CloseNotifier
+}
+
+type wrapFlusherHijackerPusherCloseNotifier struct {
+	base
+	http.Flusher
+	http.Hijacker
+	http.Pusher
+	http.CloseNotifier
+}
+
+type wrapReaderFrom struct {
+	base
+	io.ReaderFrom
+}
+
+type wrapFlusherReaderFrom struct {
+	base
+	http.Flusher
+	io.ReaderFrom
+}
+
+type wrapHijackerReaderFrom struct {
+	base
+	http.Hijacker
+	io.ReaderFrom
+}
+
+type wrapFlusherHijackerReaderFrom struct {
+	base
+	http.Flusher
+	http.Hijacker
+	io.ReaderFrom
+}
+
+type wrapPusherReaderFrom struct {
+	base
+	http.Pusher
+	io.ReaderFrom
+}
+
+type wrapFlusherPusherReaderFrom struct {
+	base
+	http.Flusher
+	http.Pusher
+	io.ReaderFrom
+}
+
+type wrapHijackerPusherReaderFrom struct {
+	base
+	http.Hijacker
+	http.Pusher
+	io.ReaderFrom
+}
+
+type wrapFlusherHijackerPusherReaderFrom struct {
+	base
+	http.Flusher
+	http.Hijacker
+	http.Pusher
+	io.ReaderFrom
+}
+
+type wrapCloseNotifierReaderFrom struct {
+	base
+	http.CloseNotifier
+	io.ReaderFrom
+}
+
+type wrapFlusherCloseNotifierReaderFrom struct {
+	base
+	http.Flusher
+	http.CloseNotifier
+	io.ReaderFrom
+}
+
+type wrapHijackerCloseNotifierReaderFrom struct {
+	base
+	http.Hijacker
+	http.CloseNotifier
+	io.ReaderFrom
+}
+
+type wrapFlusherHijackerCloseNotifierReaderFrom struct {
+	base
+	http.Flusher
+	http.Hijacker
+	http.CloseNotifier
+	io.ReaderFrom
+}
+
+type wrapPusherCloseNotifierReaderFrom struct {
+	base
+	http.Pusher
+	http.CloseNotifier
+	io.ReaderFrom
+}
+
+type wrapFlusherPusherCloseNotifierReaderFrom struct {
+	base
+	http.Flusher
+	http.Pusher
+	http.CloseNotifier
+	io.ReaderFrom
+}
+
+type wrapHijackerPusherCloseNotifierReaderFrom struct {
+	base
+	http.Hijacker
+	http.Pusher
+	http.CloseNotifier
+	io.ReaderFrom
+}
+
+type wrapFlusherHijackerPusherCloseNotifierReaderFrom struct {
+	base
+	http.Flusher
+	http.Hijacker
+	http.Pusher
+	http.CloseNotifier
+	io.ReaderFrom
+}
+
+// wrapResponseWriter returns an http.ResponseWriter that wraps rw and
+// exposes exactly the optional interfaces (http.Flusher, http.Hijacker,
+// http.Pusher, http.CloseNotifier, io.ReaderFrom) that rw itself
+// implements, following the approach taken by httpsnoop: rather than a
+// single wrapper type that always advertises every optional interface
+// (and fails at call time when the underlying writer doesn't support
+// one, as the old Hijack-only wrapper did), we select from a
+// fixed combinatorial set of wrapper types so that a type
+// assertion against the wrapper behaves exactly as it would against rw.
+func wrapResponseWriter(r *ApacheLogRecord) http.ResponseWriter {
+	rw := r.ResponseWriter
+	b := base{r}
+
+	_, isFlusher := rw.(http.Flusher)
+	_, isHijacker := rw.(http.Hijacker)
+	_, isPusher := rw.(http.Pusher)
+	_, isCloseNotifier := rw.(http.CloseNotifier)
+	_, isReaderFrom := rw.(io.ReaderFrom)
+
+	bits := 0
+	if isFlusher {
+		bits |= 1
+	}
+	if isHijacker {
+		bits |= 2
+	}
+	if isPusher {
+		bits |= 4
+	}
+	if isCloseNotifier {
+		bits |= 8
+	}
+	if isReaderFrom {
+		bits |= 16
+	}
+
+	switch bits {
+	case 0:
+		return &wrap{base: b}
+	case 1:
+		return &wrapFlusher{b, rw.(http.Flusher)}
+	case 2:
+		return &wrapHijacker{b, rw.(http.Hijacker)}
+	case 3:
+		return &wrapFlusherHijacker{b, rw.(http.Flusher), rw.(http.Hijacker)}
+	case 4:
+		return &wrapPusher{b, rw.(http.Pusher)}
+	case 5:
+		return &wrapFlusherPusher{b, rw.(http.Flusher), rw.(http.Pusher)}
+	case 6:
+		return &wrapHijackerPusher{b, rw.(http.Hijacker), rw.(http.Pusher)}
+	case 7:
+		return &wrapFlusherHijackerPusher{b, rw.(http.Flusher), rw.(http.Hijacker), rw.(http.Pusher)}
+	case 8:
+		return &wrapCloseNotifier{b, rw.(http.CloseNotifier)}
+	case 9:
+		return &wrapFlusherCloseNotifier{b, rw.(http.Flusher), rw.(http.CloseNotifier)}
+	case 10:
+		return &wrapHijackerCloseNotifier{b, rw.(http.Hijacker), rw.(http.CloseNotifier)}
+	case 11:
+		return &wrapFlusherHijackerCloseNotifier{b, rw.(http.Flusher), rw.(http.Hijacker), rw.(http.CloseNotifier)}
+	case 12:
+		return &wrapPusherCloseNotifier{b, rw.(http.Pusher), rw.(http.CloseNotifier)}
+	case 13:
+		return &wrapFlusherPusherCloseNotifier{b, rw.(http.Flusher), rw.(http.Pusher), rw.(http.CloseNotifier)}
+	case 14:
+		return &wrapHijackerPusherCloseNotifier{b, rw.(http.Hijacker), rw.(http.Pusher), rw.(http.CloseNotifier)}
+	case 15:
+		return &wrapFlusherHijackerPusherCloseNotifier{b, rw.(http.Flusher), rw.(http.Hijacker), rw.(http.Pusher), rw.(http.CloseNotifier)}
+	case 16:
+		return &wrapReaderFrom{b, rw.(io.ReaderFrom)}
+	case 17:
+		return &wrapFlusherReaderFrom{b, rw.(http.Flusher), rw.(io.ReaderFrom)}
+	case 18:
+		return &wrapHijackerReaderFrom{b, rw.(http.Hijacker), rw.(io.ReaderFrom)}
+	case 19:
+		return &wrapFlusherHijackerReaderFrom{b, rw.(http.Flusher), rw.(http.Hijacker), rw.(io.ReaderFrom)}
+	case 20:
+		return &wrapPusherReaderFrom{b, rw.(http.Pusher), rw.(io.ReaderFrom)}
+	case 21:
+		return &wrapFlusherPusherReaderFrom{b, rw.(http.Flusher), rw.(http.Pusher), rw.(io.ReaderFrom)}
+	case 22:
+		return &wrapHijackerPusherReaderFrom{b, rw.(http.Hijacker), rw.(http.Pusher), rw.(io.ReaderFrom)}
+	case 23:
+		return &wrapFlusherHijackerPusherReaderFrom{b, rw.(http.Flusher), rw.(http.Hijacker), rw.(http.Pusher), rw.(io.ReaderFrom)}
+	case 24:
+		return &wrapCloseNotifierReaderFrom{b, rw.(http.CloseNotifier), rw.(io.ReaderFrom)}
+	case 25:
+		return &wrapFlusherCloseNotifierReaderFrom{b, rw.(http.Flusher), rw.(http.CloseNotifier), rw.(io.ReaderFrom)}
+	case 26:
+		return &wrapHijackerCloseNotifierReaderFrom{b, rw.(http.Hijacker), rw.(http.CloseNotifier), rw.(io.ReaderFrom)}
+	case 27:
+		return &wrapFlusherHijackerCloseNotifierReaderFrom{b, rw.(http.Flusher), rw.(http.Hijacker), rw.(http.CloseNotifier), rw.(io.ReaderFrom)}
+	case 28:
+		return &wrapPusherCloseNotifierReaderFrom{b, rw.(http.Pusher), rw.(http.CloseNotifier), rw.(io.ReaderFrom)}
+	case 29:
+		return &wrapFlusherPusherCloseNotifierReaderFrom{b, rw.(http.Flusher), rw.(http.Pusher), rw.(http.CloseNotifier), rw.(io.ReaderFrom)}
+	case 30:
+		return &wrapHijackerPusherCloseNotifierReaderFrom{b, rw.(http.Hijacker), rw.(http.Pusher), rw.(http.CloseNotifier), rw.(io.ReaderFrom)}
+	case 31:
+		return &wrapFlusherHijackerPusherCloseNotifierReaderFrom{b, rw.(http.Flusher), rw.(http.Hijacker), rw.(http.Pusher), rw.(http.CloseNotifier), rw.(io.ReaderFrom)}
+	default:
+		panic("useful: unreachable bitmask in wrapResponseWriter")
+	}
+}
@@ -0,0 +1,92 @@
+package useful
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// fakeBase is the minimal http.ResponseWriter every fake below embeds.
+type fakeBase struct {
+	header http.Header
+}
+
+func (f *fakeBase) Header() http.Header {
+	if f.header == nil {
+		f.header = http.Header{}
+	}
+	return f.header
+}
+
+func (f *fakeBase) Write(p []byte) (int, error) { return len(p), nil }
+func (f *fakeBase) WriteHeader(int)             {}
+
+type fakeNone struct{ *fakeBase }
+
+type fakeFlusher struct{ *fakeBase }
+
+func (fakeFlusher) Flush() {}
+
+type fakeHijacker struct{ *fakeBase }
+
+func (fakeHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil }
+
+type fakeFlusherPusher struct{ *fakeBase }
+
+func (fakeFlusherPusher) Flush()                               {}
+func (fakeFlusherPusher) Push(string, *http.PushOptions) error { return nil }
+
+type fakeAll struct{ *fakeBase }
+
+func (fakeAll) Flush()                                       {}
+func (fakeAll) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil }
+func (fakeAll) Push(string, *http.PushOptions) error         { return nil }
+func (fakeAll) CloseNotify() <-chan bool                     { return nil }
+func (fakeAll) ReadFrom(io.Reader) (int64, error)            { return 0, nil }
+
+// TestWrapResponseWriterPreservesInterfaces confirms that
+// wrapResponseWriter's type assertions on the returned http.ResponseWriter
+// match exactly what the wrapped ResponseWriter itself supports, for a
+// representative sample of the interface combinations wrap_generated.go
+// enumerates.
+func TestWrapResponseWriterPreservesInterfaces(t *testing.T) {
+	tests := []struct {
+		name          string
+		rw            http.ResponseWriter
+		flusher       bool
+		hijacker      bool
+		pusher        bool
+		closeNotifier bool
+		readerFrom    bool
+	}{
+		{name: "none", rw: fakeNone{&fakeBase{}}},
+		{name: "flusher", rw: fakeFlusher{&fakeBase{}}, flusher: true},
+		{name: "hijacker", rw: fakeHijacker{&fakeBase{}}, hijacker: true},
+		{name: "flusher+pusher", rw: fakeFlusherPusher{&fakeBase{}}, flusher: true, pusher: true},
+		{name: "all", rw: fakeAll{&fakeBase{}}, flusher: true, hijacker: true, pusher: true, closeNotifier: true, readerFrom: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped := wrapResponseWriter(&ApacheLogRecord{ResponseWriter: tt.rw})
+
+			if _, ok := wrapped.(http.Flusher); ok != tt.flusher {
+				t.Errorf("Flusher assertion = %v, want %v", ok, tt.flusher)
+			}
+			if _, ok := wrapped.(http.Hijacker); ok != tt.hijacker {
+				t.Errorf("Hijacker assertion = %v, want %v", ok, tt.hijacker)
+			}
+			if _, ok := wrapped.(http.Pusher); ok != tt.pusher {
+				t.Errorf("Pusher assertion = %v, want %v", ok, tt.pusher)
+			}
+			if _, ok := wrapped.(http.CloseNotifier); ok != tt.closeNotifier {
+				t.Errorf("CloseNotifier assertion = %v, want %v", ok, tt.closeNotifier)
+			}
+			if _, ok := wrapped.(io.ReaderFrom); ok != tt.readerFrom {
+				t.Errorf("ReaderFrom assertion = %v, want %v", ok, tt.readerFrom)
+			}
+		})
+	}
+}